@@ -0,0 +1,182 @@
+package exec
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+// Provisioner returns the local-exec provisioner. The schema is identical
+// on every platform; only the termination strategy used by applyFn (signals
+// and process groups on Unix, a Job Object on Windows) is OS-specific.
+func Provisioner() terraform.ResourceProvisioner {
+	return &schema.Provisioner{
+		Schema: map[string]*schema.Schema{
+			"command": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"interpreter": &schema.Schema{
+				Type:     schema.TypeList,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+				Optional: true,
+			},
+			"working_dir": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"environment": &schema.Schema{
+				Type:     schema.TypeMap,
+				Optional: true,
+			},
+			"timeout": &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
+			},
+			"kill_grace_period": &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
+			},
+			"stderr_separate": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+			},
+			"output_format": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+		},
+
+		ApplyFunc: applyFn,
+	}
+}
+
+// provisionerConfig holds the result of validating and normalizing a
+// provisioner invocation's schema data and environment.
+type provisionerConfig struct {
+	command         string
+	cmdargs         []string
+	workingdir      string
+	cmdEnv          []string
+	timeout         int
+	killGracePeriod time.Duration
+	stderrSeparate  bool
+	jsonOutput      bool
+}
+
+// parseProvisionerConfig reads data, applies the SAFE_EXEC_* policy checks,
+// and normalizes everything applyFn needs to run the command. defaultInterpreter
+// is used when the "interpreter" field is empty, and is the one thing that
+// differs between platforms ("/bin/sh", "-c" vs. "cmd", "/C").
+func parseProvisionerConfig(data *schema.ResourceData, defaultInterpreter []string) (*provisionerConfig, error) {
+	command := data.Get("command").(string)
+	if command == "" {
+		return nil, fmt.Errorf("local-exec provisioner command must be a non-empty string")
+	}
+
+	// Execute the command with env
+	environment := data.Get("environment").(map[string]interface{})
+
+	var env []string
+	for k := range environment {
+		entry := fmt.Sprintf("%s=%s", k, environment[k].(string))
+		env = append(env, entry)
+	}
+
+	// Execute the command using a shell
+	interpreter := data.Get("interpreter").([]interface{})
+
+	var cmdargs []string
+	if len(interpreter) > 0 {
+		for _, i := range interpreter {
+			if arg, ok := i.(string); ok {
+				cmdargs = append(cmdargs, arg)
+			}
+		}
+	} else {
+		cmdargs = append(cmdargs, defaultInterpreter...)
+	}
+
+	pol, err := policyFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	if err := pol.checkInterpreter(cmdargs[0]); err != nil {
+		log.Println("denying local-exec invocation: ", err)
+		return nil, err
+	}
+
+	// Only the interpreter binary and a single flag (mirroring the built-in
+	// defaults, e.g. "/bin/sh", "-c") are exempt from the command allow-list.
+	// Anything beyond that is user-controlled content landing in the same
+	// "code to execute" slot a shell gives its "-c" argument: an interpreter
+	// of ["/bin/sh", "-c", "<payload>"] runs <payload>, not command, leaving
+	// command as an unused $0 that checkCommand alone would never see.
+	if len(cmdargs) > 2 {
+		for _, extra := range cmdargs[2:] {
+			if err := pol.checkCommand(extra); err != nil {
+				log.Println("denying local-exec invocation: ", err)
+				return nil, err
+			}
+		}
+	}
+	if err := pol.checkCommand(command); err != nil {
+		log.Println("denying local-exec invocation: ", err)
+		return nil, err
+	}
+
+	cmdargs = append(cmdargs, command)
+
+	workingdir := data.Get("working_dir").(string)
+
+	var timeout int
+	if t, ok := data.GetOk("timeout"); ok {
+		timeout = t.(int)
+	}
+
+	if t := os.Getenv(MaxTimeOut); len(t) != 0 {
+		mTimeout, err := strconv.Atoi(t)
+		if err != nil {
+			return nil, err
+		}
+		if timeout > mTimeout && mTimeout != 0 {
+			timeout = mTimeout
+		}
+		//configure the max time out of not provided in the provisioner config.
+		if timeout == 0 {
+			timeout = mTimeout
+		}
+		log.Println("max timeout configured: ", timeout)
+	}
+
+	killGracePeriod := defaultKillGracePeriod
+	if g, ok := data.GetOk("kill_grace_period"); ok {
+		killGracePeriod = time.Duration(g.(int)) * time.Second
+	}
+	if g := os.Getenv(KillGracePeriod); len(g) != 0 {
+		secs, err := strconv.Atoi(g)
+		if err != nil {
+			return nil, err
+		}
+		if maxGracePeriod := time.Duration(secs) * time.Second; maxGracePeriod != 0 && killGracePeriod > maxGracePeriod {
+			killGracePeriod = maxGracePeriod
+		}
+		log.Println("max kill grace period configured: ", killGracePeriod)
+	}
+
+	return &provisionerConfig{
+		command:         command,
+		cmdargs:         cmdargs,
+		workingdir:      workingdir,
+		cmdEnv:          append(pol.filterEnv(os.Environ()), env...),
+		timeout:         timeout,
+		killGracePeriod: killGracePeriod,
+		stderrSeparate:  data.Get("stderr_separate").(bool),
+		jsonOutput:      data.Get("output_format").(string) == "json",
+	}, nil
+}
@@ -0,0 +1,30 @@
+package exec
+
+import "time"
+
+const (
+	// maxBufSize limits how much output we collect from a local
+	// invocation. This is to prevent TF memory usage from growing
+	// to an enormous amount due to a faulty process.
+	maxBufSize = 8 * 1024
+	MaxTimeOut = "MAX_TIMEOUT"
+
+	// KillGracePeriod is the env var used to cap the grace period given to
+	// a process between the "please stop" signal and a hard kill, analogous
+	// to how MAX_TIMEOUT caps "timeout": it only ever lowers the resource's
+	// configured kill_grace_period, never raises it.
+	KillGracePeriod = "KILL_GRACE_PERIOD"
+
+	// defaultKillGracePeriod is used when the schema field is not set; a
+	// KILL_GRACE_PERIOD below it still lowers it.
+	defaultKillGracePeriod = 10 * time.Second
+
+	// pipeDrainGracePeriod bounds how long newOutputPipes waits, once the
+	// command itself has exited, for output it already wrote to reach
+	// copyOutput before force-closing the read end. Without this, force-
+	// closing the instant the command exits could cut off a line it wrote
+	// just before exiting but that copyOutput hadn't gotten to yet; waiting
+	// indefinitely would reintroduce the hang force-closing exists to avoid
+	// when an orphaned descendant keeps the write end open (golang.org/issue/18874).
+	pipeDrainGracePeriod = 1 * time.Second
+)
@@ -0,0 +1,191 @@
+package exec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/armon/circbuf"
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/mitchellh/go-linereader"
+)
+
+// outputPipes owns the pipe(s) and buffer(s) used to stream a command's
+// output to the UI and to collect it for inclusion in an error message.
+// It's shared across platforms: only how the termination path interacts
+// with the underlying process (signals vs. a Job Object) is OS-specific.
+type outputPipes struct {
+	pr, pw       *os.File
+	prErr, pwErr *os.File
+	stdoutBuf    *circbuf.Buffer
+	stderrBuf    *circbuf.Buffer
+	closeCtx     context.Context
+}
+
+// newOutputPipes wires up the stdout pipe and, if stderrSeparate is set, an
+// independent stderr pipe, and starts the goroutines that copy each to o.
+//
+// closeCtx is canceled once all copyOutput goroutines have drained their
+// pipe normally, or, once the read end(s) have been force-closed so
+// linereader unblocks, whichever of these fires first:
+//
+//   - processExitedCh is closed: cmd.Wait has already returned, so the
+//     command itself is done with the pipe. Any data still trickling in
+//     past that point is coming from some grandchild that inherited the
+//     write end and kept it open (golang.org/issue/18874); force-closing
+//     the read end pipeDrainGracePeriod after this point (unless the copies
+//     finish first) is what keeps that from leaking copyOutput forever.
+//   - killCtx fires: the SIGTERM/CTRL_BREAK_EVENT grace window elapsed
+//     without cmd.Wait returning, so the process is about to be killed
+//     outright and there's nothing left to flush.
+//
+// Until one of those happens, a well-behaved child is left free to keep
+// writing through its own grace period instead of having the pipe yanked
+// out from under it the instant termination begins.
+func newOutputPipes(processExitedCh <-chan struct{}, killCtx context.Context, o terraform.UIOutput, stderrSeparate, jsonOutput bool) (*outputPipes, error) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize pipe for output: %s", err)
+	}
+
+	var prErr, pwErr *os.File
+	if stderrSeparate {
+		prErr, pwErr, err = os.Pipe()
+		if err != nil {
+			pr.Close()
+			pw.Close()
+			return nil, fmt.Errorf("failed to initialize pipe for stderr: %s", err)
+		}
+	}
+
+	stdoutBuf, _ := circbuf.NewBuffer(maxBufSize)
+	copyDoneCh := make(chan struct{})
+	go copyOutput(o, io.TeeReader(pr, stdoutBuf), copyDoneCh, "stdout", jsonOutput)
+
+	var stderrBuf *circbuf.Buffer
+	var copyErrDoneCh chan struct{}
+	if stderrSeparate {
+		stderrBuf, _ = circbuf.NewBuffer(maxBufSize)
+		copyErrDoneCh = make(chan struct{})
+		go copyOutput(o, io.TeeReader(prErr, stderrBuf), copyErrDoneCh, "stderr", jsonOutput)
+	}
+
+	allCopyDoneCh := make(chan struct{})
+	go func() {
+		defer close(allCopyDoneCh)
+		<-copyDoneCh
+		if copyErrDoneCh != nil {
+			<-copyErrDoneCh
+		}
+	}()
+
+	closeCtx, closeCancel := context.WithCancel(context.Background())
+	go func() {
+		defer closeCancel()
+		select {
+		case <-allCopyDoneCh:
+			return
+		case <-killCtx.Done():
+		case <-processExitedCh:
+			// The command exited, but whatever it last wrote may still be
+			// sitting unread ahead of copyOutput; give it pipeDrainGracePeriod
+			// to catch up (closeWriters closing its own write end(s) normally
+			// gets it there well before then) before assuming the write end
+			// is only still open because of an orphaned descendant.
+			select {
+			case <-allCopyDoneCh:
+				return
+			case <-killCtx.Done():
+			case <-time.After(pipeDrainGracePeriod):
+			}
+		}
+		pr.Close()
+		if prErr != nil {
+			prErr.Close()
+		}
+		<-allCopyDoneCh
+	}()
+
+	return &outputPipes{
+		pr: pr, pw: pw,
+		prErr: prErr, pwErr: pwErr,
+		stdoutBuf: stdoutBuf, stderrBuf: stderrBuf,
+		closeCtx: closeCtx,
+	}, nil
+}
+
+// wire points cmd's Stdout/Stderr at the write end(s) of the pipe(s).
+func (p *outputPipes) wire(cmd *exec.Cmd) {
+	cmd.Stdout = p.pw
+	if p.pwErr != nil {
+		cmd.Stderr = p.pwErr
+	} else {
+		cmd.Stderr = p.pw
+	}
+}
+
+// closeWriters closes the write end(s) so the copyOutput goroutine(s) can
+// reach EOF once the command has exited.
+func (p *outputPipes) closeWriters() {
+	p.pw.Close()
+	if p.pwErr != nil {
+		p.pwErr.Close()
+	}
+}
+
+// wait blocks until every copyOutput goroutine has finished, per the
+// guarantee documented on newOutputPipes.
+func (p *outputPipes) wait() {
+	<-p.closeCtx.Done()
+}
+
+// errorMessage formats the failure returned by applyFn, including both
+// streams' captured output when they were collected separately.
+func (p *outputPipes) errorMessage(command string, err error, stderrSeparate bool) error {
+	if stderrSeparate {
+		return fmt.Errorf("Error running command '%s': %v. Stdout: %s. Stderr: %s",
+			command, err, p.stdoutBuf.Bytes(), p.stderrBuf.Bytes())
+	}
+	return fmt.Errorf("Error running command '%s': %v. Output: %s",
+		command, err, p.stdoutBuf.Bytes())
+}
+
+func copyOutput(o terraform.UIOutput, r io.Reader, doneCh chan<- struct{}, stream string, jsonOutput bool) {
+	defer close(doneCh)
+	lr := linereader.New(r)
+	for line := range lr.Ch {
+		if jsonOutput {
+			o.Output(formatJSONLine(stream, line))
+			continue
+		}
+		o.Output(line)
+	}
+}
+
+// jsonLine is the shape emitted per line when output_format is "json", so
+// operators can pipe the provisioner's output into log aggregators. Stream is
+// "stdout" or "stderr" for copied command output, or "meta" for applyFn's own
+// announcements (currently just the "Executing: ..." line).
+type jsonLine struct {
+	Stream string `json:"stream"`
+	Ts     string `json:"ts"`
+	Line   string `json:"line"`
+}
+
+// formatJSONLine marshals line as a jsonLine, falling back to the raw line
+// if marshaling somehow fails.
+func formatJSONLine(stream, line string) string {
+	payload, err := json.Marshal(jsonLine{
+		Stream: stream,
+		Ts:     time.Now().UTC().Format(time.RFC3339Nano),
+		Line:   line,
+	})
+	if err != nil {
+		return line
+	}
+	return string(payload)
+}
@@ -0,0 +1,167 @@
+package exec
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// AllowedCommands is the env var used to restrict which `command`
+	// strings the provisioner is allowed to run: a newline-separated list
+	// of regexes, each matched against the raw command string.
+	AllowedCommands = "SAFE_EXEC_ALLOWED_COMMANDS"
+
+	// AllowedInterpreters is the env var used to restrict which
+	// interpreter binaries the provisioner is allowed to exec: a
+	// comma-separated list of paths.
+	AllowedInterpreters = "SAFE_EXEC_ALLOWED_INTERPRETERS"
+
+	// DenyEnv is the env var used to strip sensitive variables (e.g.
+	// "AWS_*,IC_API_KEY") from the inherited environment before merging in
+	// the user-provided `environment` map.
+	DenyEnv = "SAFE_EXEC_DENY_ENV"
+)
+
+// policy is the allow-list/deny-list enforced on every invocation. A zero
+// value policy permits everything, preserving prior behavior when none of
+// the SAFE_EXEC_* env vars are set.
+type policy struct {
+	allowedCommands     []*regexp.Regexp
+	allowedInterpreters []string
+	denyEnv             []*regexp.Regexp
+}
+
+// policyFromEnv builds a policy from the SAFE_EXEC_* environment variables.
+func policyFromEnv() (*policy, error) {
+	p := &policy{}
+
+	if v := os.Getenv(AllowedCommands); len(v) != 0 {
+		for _, line := range splitAndTrim(v, "\n") {
+			re, err := regexp.Compile(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s pattern %q: %s", AllowedCommands, line, err)
+			}
+			p.allowedCommands = append(p.allowedCommands, re)
+		}
+	}
+
+	if v := os.Getenv(AllowedInterpreters); len(v) != 0 {
+		p.allowedInterpreters = splitAndTrim(v, ",")
+	}
+
+	if v := os.Getenv(DenyEnv); len(v) != 0 {
+		for _, pattern := range splitAndTrim(v, ",") {
+			re, err := regexp.Compile("^" + strings.Replace(regexp.QuoteMeta(pattern), `\*`, ".*", -1) + "$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s pattern %q: %s", DenyEnv, pattern, err)
+			}
+			p.denyEnv = append(p.denyEnv, re)
+		}
+	}
+
+	return p, nil
+}
+
+// splitAndTrim splits v on sep, trims whitespace from each piece, and drops
+// empty pieces.
+func splitAndTrim(v, sep string) []string {
+	var out []string
+	for _, piece := range strings.Split(v, sep) {
+		piece = strings.TrimSpace(piece)
+		if piece != "" {
+			out = append(out, piece)
+		}
+	}
+	return out
+}
+
+// checkCommand rejects command strings that don't match one of the allowed
+// patterns. An empty allow-list permits everything.
+func (p *policy) checkCommand(command string) error {
+	if len(p.allowedCommands) == 0 {
+		return nil
+	}
+	for _, re := range p.allowedCommands {
+		if re.MatchString(command) {
+			return nil
+		}
+	}
+	return fmt.Errorf("command %q is not permitted by %s", command, AllowedCommands)
+}
+
+// checkInterpreter resolves interpreter to an absolute, symlink-free path
+// and rejects it unless that path matches one of the allow-listed
+// interpreters (also resolved, so a symlinked entry in the allow-list still
+// matches). An empty allow-list permits everything.
+func (p *policy) checkInterpreter(interpreter string) error {
+	if len(p.allowedInterpreters) == 0 {
+		return nil
+	}
+
+	resolved, err := resolveBinary(interpreter)
+	if err != nil {
+		return fmt.Errorf("failed to resolve interpreter %q: %s", interpreter, err)
+	}
+
+	for _, allowed := range p.allowedInterpreters {
+		allowedResolved, err := resolveBinary(allowed)
+		if err != nil {
+			continue
+		}
+		if resolved == allowedResolved {
+			return nil
+		}
+	}
+	return fmt.Errorf("interpreter %q (resolved to %q) is not permitted by %s", interpreter, resolved, AllowedInterpreters)
+}
+
+// resolveBinary finds bin on PATH if it isn't already a path, makes it
+// absolute, then follows symlinks, so an allow-list check can't be bypassed by pointing a symlink
+// at a disallowed binary.
+func resolveBinary(bin string) (string, error) {
+	path, err := exec.LookPath(bin)
+	if err != nil {
+		return "", err
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	return resolved, nil
+}
+
+// filterEnv strips env entries (in "KEY=VALUE" form) whose key matches one
+// of the deny patterns.
+func (p *policy) filterEnv(env []string) []string {
+	if len(p.denyEnv) == 0 {
+		return env
+	}
+
+	filtered := make([]string, 0, len(env))
+	for _, entry := range env {
+		key := entry
+		if i := strings.IndexByte(entry, '='); i >= 0 {
+			key = entry[:i]
+		}
+
+		denied := false
+		for _, re := range p.denyEnv {
+			if re.MatchString(key) {
+				denied = true
+				break
+			}
+		}
+		if !denied {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
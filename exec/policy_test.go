@@ -0,0 +1,111 @@
+package exec
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPolicy_checkCommand_bypassAttempts(t *testing.T) {
+	t.Setenv(AllowedCommands, `^echo [a-zA-Z0-9 ]+$`)
+
+	p, err := policyFromEnv()
+	if err != nil {
+		t.Fatalf("policyFromEnv returned error: %s", err)
+	}
+
+	if err := p.checkCommand("echo hello"); err != nil {
+		t.Fatalf("expected allowed command to pass, got: %s", err)
+	}
+
+	bypasses := []string{
+		"echo hello; rm -rf /",
+		"echo `rm -rf /`",
+		"echo $(rm -rf /)",
+	}
+	for _, command := range bypasses {
+		if err := p.checkCommand(command); err == nil {
+			t.Fatalf("expected command %q to be denied", command)
+		}
+	}
+}
+
+func TestPolicy_checkCommand_emptyAllowListPermitsEverything(t *testing.T) {
+	p := &policy{}
+	if err := p.checkCommand("echo hello; rm -rf /"); err != nil {
+		t.Fatalf("expected empty allow-list to permit everything, got: %s", err)
+	}
+}
+
+func TestPolicy_checkInterpreter_symlinkBypass(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlink creation requires elevated privileges on windows")
+	}
+
+	dir := t.TempDir()
+
+	disallowed := filepath.Join(dir, "disallowed-shell")
+	if err := os.WriteFile(disallowed, []byte("#!/bin/sh\n"), 0o755); err != nil {
+		t.Fatalf("failed to write fake interpreter: %s", err)
+	}
+
+	// A symlink named like an allowed interpreter, but pointing at a binary
+	// that isn't.
+	symlink := filepath.Join(dir, "sh")
+	if err := os.Symlink(disallowed, symlink); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+
+	t.Setenv(AllowedInterpreters, "/bin/sh")
+
+	p, err := policyFromEnv()
+	if err != nil {
+		t.Fatalf("policyFromEnv returned error: %s", err)
+	}
+
+	if err := p.checkInterpreter(symlink); err == nil {
+		t.Fatal("expected a symlink to a disallowed interpreter to be denied")
+	}
+}
+
+func TestPolicy_checkInterpreter_emptyAllowListPermitsEverything(t *testing.T) {
+	p := &policy{}
+	if err := p.checkInterpreter("/bin/sh"); err != nil {
+		t.Fatalf("expected empty allow-list to permit everything, got: %s", err)
+	}
+}
+
+func TestPolicy_filterEnv(t *testing.T) {
+	t.Setenv(DenyEnv, "AWS_*,IC_API_KEY")
+
+	p, err := policyFromEnv()
+	if err != nil {
+		t.Fatalf("policyFromEnv returned error: %s", err)
+	}
+
+	filtered := p.filterEnv([]string{
+		"AWS_SECRET_ACCESS_KEY=shh",
+		"IC_API_KEY=shh",
+		"PATH=/usr/bin",
+	})
+
+	for _, denied := range []string{"AWS_SECRET_ACCESS_KEY", "IC_API_KEY"} {
+		for _, entry := range filtered {
+			if strings.HasPrefix(entry, denied+"=") {
+				t.Fatalf("expected %s to be stripped from environment, got %v", denied, filtered)
+			}
+		}
+	}
+
+	found := false
+	for _, entry := range filtered {
+		if entry == "PATH=/usr/bin" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected PATH to survive filtering, got %v", filtered)
+	}
+}
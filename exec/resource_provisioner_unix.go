@@ -0,0 +1,124 @@
+// +build linux darwin
+
+package exec
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func applyFn(ctx context.Context) error {
+	data := ctx.Value(schema.ProvConfigDataKey).(*schema.ResourceData)
+	o := ctx.Value(schema.ProvOutputKey).(terraform.UIOutput)
+
+	cfg, err := parseProvisionerConfig(data, []string{"/bin/sh", "-c"})
+	if err != nil {
+		return err
+	}
+
+	// cmdCtx is the "please stop" context: once it expires (or the parent
+	// context is canceled) we ask the process group to terminate via
+	// SIGTERM and give it killGracePeriod to exit on its own.
+	cmdCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.timeout)*time.Second)
+	defer cancel()
+
+	// killCtx is only canceled once the grace period has elapsed, so it's
+	// the one wired into exec.CommandContext: Cmd.Wait still returns
+	// normally when the child cooperates with the SIGTERM above.
+	killCtx, killCancel := context.WithCancel(context.Background())
+	defer killCancel()
+
+	// Setup the command
+	cmd := exec.CommandContext(killCtx, cfg.cmdargs[0], cfg.cmdargs[1:]...)
+
+	sysProcessAttr := syscall.SysProcAttr{Setpgid: true}
+	cmd.SysProcAttr = &sysProcessAttr
+
+	// Dir specifies the working directory of the command.
+	// If Dir is the empty string (this is default), runs the command
+	// in the calling process's current directory.
+	cmd.Dir = cfg.workingdir
+	// Env specifies the environment of the command.
+	// By default will use the calling process's environment
+	cmd.Env = cfg.cmdEnv
+
+	// processExitedCh is closed once cmd.Wait returns, regardless of which
+	// branch of the select below gets there: it's what lets newOutputPipes
+	// force-close a pipe still held open by an orphaned grandchild even when
+	// the command itself exited well before killCtx ever fires.
+	processExitedCh := make(chan struct{})
+
+	pipes, err := newOutputPipes(processExitedCh, killCtx, o, cfg.stderrSeparate, cfg.jsonOutput)
+	if err != nil {
+		return err
+	}
+	pipes.wire(cmd)
+
+	// Output what we're about to run. When output_format is "json" this must
+	// be wrapped like every other line copyOutput emits, or a log-aggregator
+	// consumer sees one malformed line per invocation.
+	executing := fmt.Sprintf("Executing: %q", cfg.cmdargs)
+	if cfg.jsonOutput {
+		executing = formatJSONLine("meta", executing)
+	}
+	o.Output(executing)
+
+	// Start the command
+	err = cmd.Start()
+	if err == nil {
+		waitErrCh := make(chan error, 1)
+		go func() { waitErrCh <- cmd.Wait() }()
+
+		select {
+		case err = <-waitErrCh:
+		case <-ctx.Done():
+			err = terminateGracefully(cmd, killCancel, cfg.killGracePeriod, waitErrCh)
+		case <-cmdCtx.Done():
+			err = terminateGracefully(cmd, killCancel, cfg.killGracePeriod, waitErrCh)
+		}
+	}
+	close(processExitedCh)
+
+	// Close the write-end(s) of the pipe(s) so that the goroutines mirroring
+	// output end properly.
+	pipes.closeWriters()
+
+	// Wait for the output to finish copying. Guaranteed to return: either
+	// every copyOutput goroutine drained normally, or the watcher inside
+	// newOutputPipes force-closed the read end(s) so they could.
+	pipes.wait()
+
+	if err != nil {
+		return pipes.errorMessage(cfg.command, err, cfg.stderrSeparate)
+	}
+
+	return nil
+}
+
+// terminateGracefully asks the process group to stop via SIGTERM and gives
+// it gracePeriod to exit on its own before escalating to SIGKILL. killCancel
+// is only invoked once the grace period elapses, so exec.CommandContext's
+// own teardown (wired to killCtx) stays a no-op for cooperating children.
+func terminateGracefully(cmd *exec.Cmd, killCancel context.CancelFunc, gracePeriod time.Duration, waitErrCh <-chan error) error {
+	pgid, pgidErr := syscall.Getpgid(cmd.Process.Pid)
+	if pgidErr == nil {
+		syscall.Kill(-pgid, syscall.SIGTERM)
+	}
+
+	select {
+	case err := <-waitErrCh:
+		return err
+	case <-time.After(gracePeriod):
+		killCancel()
+		if pgidErr == nil {
+			syscall.Kill(-pgid, syscall.SIGKILL)
+		}
+		return <-waitErrCh
+	}
+}
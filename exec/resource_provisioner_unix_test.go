@@ -0,0 +1,286 @@
+// +build linux darwin
+
+package exec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+type testUIOutput struct{}
+
+func (testUIOutput) Output(string) {}
+
+// collectingUIOutput records every line passed to Output, for tests that
+// need to assert on the actual output produced rather than just that
+// applyFn ran without error.
+type collectingUIOutput struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (c *collectingUIOutput) Output(line string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lines = append(c.lines, line)
+}
+
+func (c *collectingUIOutput) snapshot() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.lines...)
+}
+
+// TestApplyFn_copyOutputDoesNotLeak reproduces the golang.org/issue/18874
+// scenario: the command forks a grandchild that inherits the write end of
+// the output pipe and keeps it open past the command's own exit. Before the
+// fix, copyOutput would block on that pipe forever and applyFn's goroutine
+// would leak even though applyFn itself returned on schedule.
+func TestApplyFn_copyOutputDoesNotLeak(t *testing.T) {
+	raw := map[string]interface{}{
+		"command": "sleep 30 &",
+		"timeout": 2,
+	}
+	data := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, raw)
+
+	ctx := context.WithValue(context.Background(), schema.ProvConfigDataKey, data)
+	ctx = context.WithValue(ctx, schema.ProvOutputKey, testUIOutput{})
+
+	before := runtime.NumGoroutine()
+
+	done := make(chan error, 1)
+	go func() { done <- applyFn(ctx) }()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("applyFn did not return within 5s of its 2s timeout")
+	}
+
+	// copyOutput's goroutines wind down right after the pipe is force-closed;
+	// give them a brief window to actually exit before sampling.
+	deadline := time.Now().Add(2 * time.Second)
+	after := runtime.NumGoroutine()
+	for after > before && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		after = runtime.NumGoroutine()
+	}
+
+	if after > before {
+		t.Fatalf("goroutine leak: started with %d, ended with %d", before, after)
+	}
+}
+
+// TestApplyFn_terminateGracefully_escalatesAfterGracePeriod covers the
+// SIGTERM-ignored path: the trapped ignore is inherited across the shell's
+// own exec of "sleep", so the whole process group survives the initial
+// SIGTERM and is only reaped once the grace period elapses and SIGKILL is
+// sent.
+func TestApplyFn_terminateGracefully_escalatesAfterGracePeriod(t *testing.T) {
+	raw := map[string]interface{}{
+		"command":           "trap '' TERM; sleep 30",
+		"timeout":           1,
+		"kill_grace_period": 1,
+	}
+	data := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, raw)
+
+	ctx := context.WithValue(context.Background(), schema.ProvConfigDataKey, data)
+	ctx = context.WithValue(ctx, schema.ProvOutputKey, testUIOutput{})
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- applyFn(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(8 * time.Second):
+		t.Fatal("applyFn did not return after escalating to SIGKILL")
+	}
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatal("expected an error: the child ignores SIGTERM and must be SIGKILLed")
+	}
+	// cmdCtx fires at ~1s, then terminateGracefully must wait out the full
+	// 1s grace period before escalating to SIGKILL.
+	if elapsed < 2*time.Second {
+		t.Fatalf("child was reaped before the grace period elapsed: %s", elapsed)
+	}
+}
+
+// TestApplyFn_terminateGracefully_noEscalationOnCleanExit covers the
+// cooperating-child path: the shell's own custom TERM handler does not
+// survive its exec of "sleep" (only an ignore-disposition does), so the
+// forked sleep dies immediately and the shell's trap then exits cleanly —
+// terminateGracefully must return as soon as that happens, not sleep out
+// the rest of the (much longer) grace period.
+func TestApplyFn_terminateGracefully_noEscalationOnCleanExit(t *testing.T) {
+	raw := map[string]interface{}{
+		"command":           "trap 'exit 0' TERM; sleep 30",
+		"timeout":           1,
+		"kill_grace_period": 5,
+	}
+	data := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, raw)
+
+	ctx := context.WithValue(context.Background(), schema.ProvConfigDataKey, data)
+	ctx = context.WithValue(ctx, schema.ProvOutputKey, testUIOutput{})
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- applyFn(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(8 * time.Second):
+		t.Fatal("applyFn did not return")
+	}
+	elapsed := time.Since(start)
+
+	if err != nil {
+		t.Fatalf("expected a clean exit once the child traps SIGTERM, got: %s", err)
+	}
+	// cmdCtx fires at ~1s and the child exits right away; if terminateGracefully
+	// waited out the full 5s grace period instead of returning as soon as
+	// waitErrCh fired, this would take close to 6s.
+	if elapsed > 3*time.Second {
+		t.Fatalf("terminateGracefully waited past the child's clean exit: %s", elapsed)
+	}
+}
+
+// TestApplyFn_outputSurvivesGracePeriod covers the other half of
+// terminateGracefully's contract: the child must be able to keep writing to
+// its output pipe for the whole grace period, not just keep running. Before
+// the fix, newOutputPipes force-closed the read end as soon as cmdCtx fired
+// (the same instant SIGTERM was sent), so a child that traps SIGTERM and
+// tries to flush output during the grace window died with a broken pipe
+// instead of getting the window it was promised.
+func TestApplyFn_outputSurvivesGracePeriod(t *testing.T) {
+	raw := map[string]interface{}{
+		"command":           "trap 'echo flushed; exit 0' TERM; sleep 30",
+		"timeout":           1,
+		"kill_grace_period": 2,
+	}
+	data := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, raw)
+
+	ctx := context.WithValue(context.Background(), schema.ProvConfigDataKey, data)
+	out := &collectingUIOutput{}
+	ctx = context.WithValue(ctx, schema.ProvOutputKey, out)
+
+	done := make(chan error, 1)
+	go func() { done <- applyFn(ctx) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("applyFn returned an error: %s", err)
+		}
+	case <-time.After(8 * time.Second):
+		t.Fatal("applyFn did not return")
+	}
+
+	for _, ln := range out.snapshot() {
+		if ln == "flushed" {
+			return
+		}
+	}
+	t.Fatalf("expected the trap's flush during the grace period to reach the output, got %v", out.snapshot())
+}
+
+// TestApplyFn_stderrSeparateJSONOutput exercises the second pipe wired up by
+// stderr_separate and the per-line JSON wrapping done when output_format is
+// "json", asserting on the actual stream/line fields produced rather than
+// just that the code runs.
+func TestApplyFn_stderrSeparateJSONOutput(t *testing.T) {
+	raw := map[string]interface{}{
+		"command":         "echo out-line; echo err-line 1>&2",
+		"timeout":         5,
+		"stderr_separate": true,
+		"output_format":   "json",
+	}
+	data := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, raw)
+
+	ctx := context.WithValue(context.Background(), schema.ProvConfigDataKey, data)
+	out := &collectingUIOutput{}
+	ctx = context.WithValue(ctx, schema.ProvOutputKey, out)
+
+	done := make(chan error, 1)
+	go func() { done <- applyFn(ctx) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("applyFn returned an error: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("applyFn did not return")
+	}
+
+	var sawStdout, sawStderr, sawMeta bool
+	for _, ln := range out.snapshot() {
+		// Every line, including the "Executing: ..." announcement, must be
+		// wrapped so a log-aggregator consumer never sees a malformed line.
+		var line jsonLine
+		if err := json.Unmarshal([]byte(ln), &line); err != nil {
+			t.Fatalf("expected every emitted line to be valid JSON, got %q: %s", ln, err)
+		}
+		switch {
+		case line.Stream == "stdout" && line.Line == "out-line":
+			sawStdout = true
+		case line.Stream == "stderr" && line.Line == "err-line":
+			sawStderr = true
+		case line.Stream == "meta":
+			sawMeta = true
+		}
+	}
+
+	if !sawStdout {
+		t.Fatalf("expected a stdout JSON line, got %v", out.snapshot())
+	}
+	if !sawStderr {
+		t.Fatalf("expected a stderr JSON line on its own pipe, got %v", out.snapshot())
+	}
+	if !sawMeta {
+		t.Fatalf("expected the \"Executing: ...\" announcement as a meta JSON line, got %v", out.snapshot())
+	}
+}
+
+// TestApplyFn_allowedCommandsCannotBeBypassedViaInterpreter reproduces the
+// bypass where the real payload is smuggled in via "interpreter" instead of
+// "command": "/bin/sh -c <payload> <command>" runs <payload>, with <command>
+// relegated to $0 and never executed. checkCommand alone, which only ever
+// saw the unused <command>, would let this through.
+func TestApplyFn_allowedCommandsCannotBeBypassedViaInterpreter(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "bypassed")
+
+	t.Setenv(AllowedCommands, `^echo [a-zA-Z0-9 ]+$`)
+
+	raw := map[string]interface{}{
+		"command":     "echo hi",
+		"interpreter": []interface{}{"/bin/sh", "-c", fmt.Sprintf("touch %s", marker)},
+		"timeout":     5,
+	}
+	data := schema.TestResourceDataRaw(t, Provisioner().(*schema.Provisioner).Schema, raw)
+
+	ctx := context.WithValue(context.Background(), schema.ProvConfigDataKey, data)
+	ctx = context.WithValue(ctx, schema.ProvOutputKey, testUIOutput{})
+
+	if err := applyFn(ctx); err == nil {
+		t.Fatal("expected the disallowed interpreter payload to be denied")
+	}
+
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("interpreter payload executed despite failing the command allow-list")
+	}
+}
@@ -0,0 +1,174 @@
+// +build windows
+
+package exec
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+	"golang.org/x/sys/windows"
+)
+
+// jobHandle wraps a Job Object handle so it's closed at most once: once the
+// grace period elapses, terminateGracefully closes it to kill the process
+// tree via JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE, and applyFn's own deferred
+// cleanup must not close it again afterwards.
+type jobHandle struct {
+	handle windows.Handle
+	once   sync.Once
+}
+
+func (j *jobHandle) close() {
+	j.once.Do(func() {
+		windows.CloseHandle(j.handle)
+	})
+}
+
+func applyFn(ctx context.Context) error {
+	data := ctx.Value(schema.ProvConfigDataKey).(*schema.ResourceData)
+	o := ctx.Value(schema.ProvOutputKey).(terraform.UIOutput)
+
+	cfg, err := parseProvisionerConfig(data, []string{"cmd", "/C"})
+	if err != nil {
+		return err
+	}
+
+	// cmdCtx is the "please stop" context: once it expires (or the parent
+	// context is canceled) we ask the process tree to terminate via
+	// CTRL_BREAK_EVENT and give it killGracePeriod to exit on its own.
+	cmdCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.timeout)*time.Second)
+	defer cancel()
+
+	// killCtx is only canceled once the grace period has elapsed, so it's
+	// the one wired into exec.CommandContext: Cmd.Wait still returns
+	// normally when the child cooperates with the CTRL_BREAK_EVENT above.
+	killCtx, killCancel := context.WithCancel(context.Background())
+	defer killCancel()
+
+	// Setup the command
+	cmd := exec.CommandContext(killCtx, cfg.cmdargs[0], cfg.cmdargs[1:]...)
+
+	// CREATE_NEW_PROCESS_GROUP lets us deliver CTRL_BREAK_EVENT to the whole
+	// tree, and keeps the child out of our own console's Ctrl+C handling.
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: windows.CREATE_NEW_PROCESS_GROUP}
+
+	// Dir specifies the working directory of the command.
+	// If Dir is the empty string (this is default), runs the command
+	// in the calling process's current directory.
+	cmd.Dir = cfg.workingdir
+	// Env specifies the environment of the command.
+	// By default will use the calling process's environment
+	cmd.Env = cfg.cmdEnv
+
+	// A Job Object groups the child and every process it spawns so that
+	// closing the job handle reliably terminates the whole tree, mirroring
+	// the Setpgid/Kill(-pgid, ...) behavior used on Unix.
+	handle, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create job object: %s", err)
+	}
+	job := &jobHandle{handle: handle}
+	defer job.close()
+
+	info := windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION{
+		BasicLimitInformation: windows.JOBOBJECT_BASIC_LIMIT_INFORMATION{
+			LimitFlags: windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE,
+		},
+	}
+	if _, err := windows.SetInformationJobObject(
+		handle,
+		windows.JobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(&info)),
+		uint32(unsafe.Sizeof(info)),
+	); err != nil {
+		return fmt.Errorf("failed to configure job object: %s", err)
+	}
+
+	// processExitedCh is closed once cmd.Wait returns, regardless of which
+	// branch of the select below gets there: it's what lets newOutputPipes
+	// force-close a pipe still held open by an orphaned descendant even when
+	// the command itself exited well before killCtx ever fires.
+	processExitedCh := make(chan struct{})
+
+	pipes, err := newOutputPipes(processExitedCh, killCtx, o, cfg.stderrSeparate, cfg.jsonOutput)
+	if err != nil {
+		return err
+	}
+	pipes.wire(cmd)
+
+	// Output what we're about to run. When output_format is "json" this must
+	// be wrapped like every other line copyOutput emits, or a log-aggregator
+	// consumer sees one malformed line per invocation.
+	executing := fmt.Sprintf("Executing: %q", cfg.cmdargs)
+	if cfg.jsonOutput {
+		executing = formatJSONLine("meta", executing)
+	}
+	o.Output(executing)
+
+	// Start the command
+	err = cmd.Start()
+	if err == nil {
+		procHandle, procErr := windows.OpenProcess(windows.PROCESS_ALL_ACCESS, false, uint32(cmd.Process.Pid))
+		if procErr != nil {
+			log.Println("failed to open process handle for job object: ", procErr)
+		} else {
+			defer windows.CloseHandle(procHandle)
+			if jobErr := windows.AssignProcessToJobObject(handle, procHandle); jobErr != nil {
+				log.Println("failed to assign process to job object: ", jobErr)
+			}
+		}
+
+		waitErrCh := make(chan error, 1)
+		go func() { waitErrCh <- cmd.Wait() }()
+
+		select {
+		case err = <-waitErrCh:
+		case <-ctx.Done():
+			err = terminateGracefully(cmd, job, killCancel, cfg.killGracePeriod, waitErrCh)
+		case <-cmdCtx.Done():
+			err = terminateGracefully(cmd, job, killCancel, cfg.killGracePeriod, waitErrCh)
+		}
+	}
+	close(processExitedCh)
+
+	// Close the write-end(s) of the pipe(s) so that the goroutines mirroring
+	// output end properly.
+	pipes.closeWriters()
+
+	// Wait for the output to finish copying. Guaranteed to return: either
+	// every copyOutput goroutine drained normally, or the watcher inside
+	// newOutputPipes force-closed the read end(s) so they could.
+	pipes.wait()
+
+	if err != nil {
+		return pipes.errorMessage(cfg.command, err, cfg.stderrSeparate)
+	}
+
+	return nil
+}
+
+// terminateGracefully asks the process tree to stop via CTRL_BREAK_EVENT and
+// gives it gracePeriod to exit on its own before escalating to closing the
+// job object, which kills every process still in it. killCancel is only
+// invoked once the grace period elapses, so exec.CommandContext's own
+// teardown (wired to killCtx) stays a no-op for cooperating children.
+func terminateGracefully(cmd *exec.Cmd, job *jobHandle, killCancel context.CancelFunc, gracePeriod time.Duration, waitErrCh <-chan error) error {
+	windows.GenerateConsoleCtrlEvent(windows.CTRL_BREAK_EVENT, uint32(cmd.Process.Pid))
+
+	select {
+	case err := <-waitErrCh:
+		return err
+	case <-time.After(gracePeriod):
+		killCancel()
+		job.close()
+		return <-waitErrCh
+	}
+}